@@ -0,0 +1,352 @@
+package agentfs
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/livekit/livekit-cli/pkg/agentfs/ignore"
+)
+
+// manifestFileName is the name of the manifest entry written at the
+// root of an incremental archive, listing every file in the directory
+// (not just the ones included in this upload) so the server can
+// reconstruct the full tree by combining the upload with cached blobs.
+// It's namespaced and dotted so it can't collide with an ordinary
+// project file (e.g. a Chrome extension's own manifest.json); hashDirectory
+// additionally rejects a real file that happens to use this exact path.
+const manifestFileName = ".livekit-agentfs-manifest.json"
+
+// ManifestEntry describes one file in the directory being archived,
+// identified by the SHA-256 of its content.
+type ManifestEntry struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+	Mode int64  `json:"mode"`
+}
+
+// Manifest lists every regular file under the archived directory,
+// whether or not its content is included in a given upload.
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// ManifestHead asks the server which blobs from a previous upload of
+// manifestID it already has, so they don't need to be re-uploaded. It's
+// the server-side counterpart to the local hash cache.
+type ManifestHead interface {
+	// HeadManifest returns the hashes the server already has for
+	// manifestID, keyed by manifest path, and the URL the caller should
+	// PUT the incremental tarball to.
+	HeadManifest(manifestID string) (existingHashes map[string]string, uploadURL string, err error)
+}
+
+// UploadIncrementalOptions configures UploadTarballIncremental.
+type UploadIncrementalOptions struct {
+	// ExcludeFiles are additional dockerignore-style patterns applied
+	// on top of the standard excludes and the context's .dockerignore.
+	ExcludeFiles []string
+	// ManifestID identifies this directory's upload history to Head,
+	// e.g. a previous deploy's manifest ID.
+	ManifestID string
+	// Head reports which blobs the server already has for ManifestID.
+	Head ManifestHead
+	// Compression selects the codec the tarball is compressed with.
+	// Defaults to CompressionGzip.
+	Compression Compression
+	Client      *http.Client
+}
+
+// UploadTarballIncremental hashes every regular file under directory,
+// asks opts.Head which of those hashes the server already has for
+// opts.ManifestID, and uploads a tarball containing only the
+// missing or changed files plus a manifest (manifestFileName) listing every file
+// with its hash, size, and mode so the server can reconstruct the full
+// tree by combining this upload with its cached blobs. File hashes are
+// cached locally (see loadHashCache) so unchanged files aren't
+// rehashed on the next call.
+func UploadTarballIncremental(ctx context.Context, directory string, opts UploadIncrementalOptions) (*Manifest, error) {
+	matcher, err := newTarballIgnoreMatcher(directory, opts.ExcludeFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := loadHashCache(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := hashDirectory(directory, matcher, cache)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.save(); err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{Entries: entries}
+
+	existingHashes, uploadURL, err := opts.Head.HeadManifest(opts.ManifestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query existing manifest: %w", err)
+	}
+
+	missing := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if existingHashes[entry.Path] != entry.Hash {
+			missing[entry.Path] = true
+		}
+	}
+
+	sink := &SinglePutSink{PresignedUrl: uploadURL, Client: opts.Client, Compression: opts.Compression}
+
+	pr, pw := io.Pipe()
+	go func() {
+		compressWriter, err := newCompressWriter(pw, opts.Compression)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		tarWriter := tar.NewWriter(compressWriter)
+
+		if err := writeManifestTarball(tarWriter, directory, manifest, missing); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if err := tarWriter.Close(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if err := compressWriter.Close(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.Close()
+	}()
+
+	if err := sink.Send(ctx, pr); err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// writeManifestTarball writes the manifest as manifestFileName at the archive root,
+// followed by the content of every entry whose path is in missing.
+func writeManifestTarball(tarWriter *tar.Writer, directory string, manifest *Manifest, missing map[string]bool) error {
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if err := tarWriter.WriteHeader(&tar.Header{
+		Name:     manifestFileName,
+		Typeflag: tar.TypeReg,
+		Mode:     0o644,
+		Size:     int64(len(manifestJSON)),
+		ModTime:  time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to write manifest header: %w", err)
+	}
+	if _, err := tarWriter.Write(manifestJSON); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	for _, entry := range manifest.Entries {
+		if !missing[entry.Path] {
+			continue
+		}
+
+		path := filepath.Join(directory, entry.Path)
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", path, err)
+		}
+
+		if err := tarWriter.WriteHeader(&tar.Header{
+			Name:     entry.Path,
+			Typeflag: tar.TypeReg,
+			Mode:     entry.Mode,
+			Size:     entry.Size,
+			ModTime:  time.Now(),
+		}); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write tar header for file %s: %w", path, err)
+		}
+		if _, err := io.Copy(tarWriter, file); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to copy file content for %s: %w", path, err)
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to close file %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// hashDirectory walks directory and returns a ManifestEntry for every
+// regular file matcher does not exclude, reusing cache's hash for a
+// file whose size and mtime haven't changed and rehashing it otherwise.
+func hashDirectory(directory string, matcher *ignore.Matcher, cache *hashCache) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(directory, path)
+		if err != nil {
+			return fmt.Errorf("failed to calculate relative path for %s: %w", path, err)
+		}
+
+		excluded, err := matcher.Match(relPath)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if relPath == manifestFileName {
+			return fmt.Errorf("file %s collides with the reserved manifest path; rename or exclude it", path)
+		}
+
+		hash, err := cache.hash(relPath, path, info)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, ManifestEntry{
+			Path: relPath,
+			Hash: hash,
+			Size: info.Size(),
+			Mode: int64(info.Mode().Perm()),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory: %w", err)
+	}
+	return entries, nil
+}
+
+// hashCache is a local, on-disk index of file hashes keyed by path
+// relative to the directory it was built for, letting repeated uploads
+// of a mostly-unchanged directory skip rehashing every file.
+type hashCache struct {
+	path    string
+	entries map[string]hashCacheEntry
+}
+
+type hashCacheEntry struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// loadHashCache loads the hash cache for directory from
+// ~/.livekit/agentfs-cache/<dir-hash>.json, returning an empty cache if
+// it doesn't exist yet.
+func loadHashCache(directory string) (*hashCache, error) {
+	path, err := hashCachePath(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &hashCache{path: path, entries: make(map[string]hashCacheEntry)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, fmt.Errorf("failed to read hash cache %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &cache.entries); err != nil {
+		return nil, fmt.Errorf("failed to parse hash cache %s: %w", path, err)
+	}
+	return cache, nil
+}
+
+// save writes the cache back to disk, creating its parent directory if
+// necessary.
+func (c *hashCache) save() error {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal hash cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create hash cache directory: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write hash cache %s: %w", c.path, err)
+	}
+	return nil
+}
+
+// hash returns the SHA-256 hex digest of the regular file at path
+// (relPath within the cache), reusing the cached digest when size and
+// mtime match the cache entry and rehashing on any mismatch.
+func (c *hashCache) hash(relPath, path string, info os.FileInfo) (string, error) {
+	if entry, ok := c.entries[relPath]; ok {
+		if entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+			return entry.Hash, nil
+		}
+	}
+
+	hash, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	c.entries[relPath] = hashCacheEntry{Hash: hash, Size: info.Size(), ModTime: info.ModTime()}
+	return hash, nil
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("failed to hash file %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashCachePath returns the path of the on-disk hash cache for
+// directory, keyed by the SHA-256 of its absolute path so distinct
+// directories never collide.
+func hashCachePath(directory string) (string, error) {
+	abs, err := filepath.Abs(directory)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve absolute path for %s: %w", directory, err)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve home directory: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(home, ".livekit", "agentfs-cache", hex.EncodeToString(sum[:])+".json"), nil
+}
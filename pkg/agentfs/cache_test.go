@@ -0,0 +1,217 @@
+package agentfs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// gunzipBody reads r's gzipped tar body and returns the name of every
+// entry it contains, in archive order.
+func gunzipBody(r *http.Request) ([]string, error) {
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var names []string
+	tarReader := tar.NewReader(gz)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, header.Name)
+	}
+}
+
+func TestHashDirectoryReusesCacheOnUnchangedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	matcher, err := newTarballIgnoreMatcher(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &hashCache{path: filepath.Join(dir, "cache.json"), entries: make(map[string]hashCacheEntry)}
+
+	first, err := hashDirectory(dir, matcher, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 || first[0].Hash == "" {
+		t.Fatalf("unexpected entries: %+v", first)
+	}
+
+	// Poison the cache entry's hash; since size and mtime still match
+	// the file on disk, hashDirectory should trust the cache and
+	// return the poisoned value rather than rehashing.
+	entry := cache.entries["a.txt"]
+	entry.Hash = "stale-hash-reused"
+	cache.entries["a.txt"] = entry
+
+	second, err := hashDirectory(dir, matcher, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second[0].Hash != "stale-hash-reused" {
+		t.Errorf("expected cached hash to be reused, got %q", second[0].Hash)
+	}
+}
+
+func TestHashDirectoryRehashesOnMismatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	matcher, err := newTarballIgnoreMatcher(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &hashCache{path: filepath.Join(dir, "cache.json"), entries: make(map[string]hashCacheEntry)}
+
+	before, err := hashDirectory(dir, matcher, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Force an mtime change so the cache entry no longer matches.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("goodbye"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := hashDirectory(dir, matcher, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after[0].Hash == before[0].Hash {
+		t.Error("expected hash to change after file content changed")
+	}
+}
+
+func TestHashDirectoryRejectsReservedManifestPath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, manifestFileName), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	matcher, err := newTarballIgnoreMatcher(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &hashCache{path: filepath.Join(dir, "cache.json"), entries: make(map[string]hashCacheEntry)}
+
+	if _, err := hashDirectory(dir, matcher, cache); err == nil {
+		t.Error("expected an error for a real file at the reserved manifest path")
+	}
+}
+
+func TestHashCacheSaveLoadRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	cache := &hashCache{path: filepath.Join(dir, "cache.json"), entries: map[string]hashCacheEntry{
+		"a.txt": {Hash: "deadbeef", Size: 5, ModTime: time.Now().Truncate(time.Second)},
+	}}
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(cache.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded map[string]hashCacheEntry
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded["a.txt"].Hash != "deadbeef" {
+		t.Errorf("round-tripped hash = %q, want %q", loaded["a.txt"].Hash, "deadbeef")
+	}
+}
+
+type fakeManifestHead struct {
+	existingHashes map[string]string
+	uploadURL      string
+}
+
+func (f *fakeManifestHead) HeadManifest(manifestID string) (map[string]string, string, error) {
+	return f.existingHashes, f.uploadURL, nil
+}
+
+func TestUploadTarballIncrementalSkipsUnchangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "unchanged.txt"), []byte("same as server"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "changed.txt"), []byte("new content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	unchangedHash, err := hashFile(filepath.Join(dir, "unchanged.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var uploadedNames []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := gunzipBody(r)
+		if err != nil {
+			t.Errorf("failed to read uploaded tarball: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		uploadedNames = compressed
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	head := &fakeManifestHead{
+		existingHashes: map[string]string{"unchanged.txt": unchangedHash, "changed.txt": "stale-hash"},
+		uploadURL:      server.URL,
+	}
+
+	manifest, err := UploadTarballIncremental(context.Background(), dir, UploadIncrementalOptions{
+		ManifestID:  "test-manifest",
+		Head:        head,
+		Compression: CompressionGzip,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Entries) != 2 {
+		t.Fatalf("expected 2 manifest entries, got %d", len(manifest.Entries))
+	}
+
+	wantNames := map[string]bool{manifestFileName: true, "changed.txt": true}
+	if len(uploadedNames) != len(wantNames) {
+		t.Fatalf("uploaded entries = %v, want %v", uploadedNames, wantNames)
+	}
+	for _, name := range uploadedNames {
+		if !wantNames[name] {
+			t.Errorf("unexpected entry %q in incremental tarball", name)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package agentfs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects the codec used to compress an archived tarball.
+type Compression int
+
+const (
+	// CompressionGzip is the default codec agentfs has always used.
+	CompressionGzip Compression = iota
+	// CompressionZstd trades a small amount of compression ratio for
+	// 3-5x faster decompression, which matters on agent-runner
+	// cold-start paths.
+	CompressionZstd
+	// CompressionNone writes a plain, uncompressed tar stream, useful
+	// for payloads (e.g. ML weights) that are already compressed and
+	// would just pay the CPU cost for nothing.
+	CompressionNone
+)
+
+// String renders the wire form of a Compression value, the same form
+// used by NegotiateCompression and Content-Encoding headers (except
+// that CompressionNone sends no Content-Encoding header at all).
+func (c Compression) String() string {
+	switch c {
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionNone:
+		return "identity"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseCompression parses the wire form produced by Compression.String,
+// case-insensitively, along with the aliases "gz", "zst" and "none"; an
+// empty string parses as CompressionNone. Anything else is an error.
+func ParseCompression(s string) (Compression, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "gzip", "gz":
+		return CompressionGzip, nil
+	case "zstd", "zst":
+		return CompressionZstd, nil
+	case "none", "identity", "":
+		return CompressionNone, nil
+	default:
+		return 0, fmt.Errorf("unknown compression %q", s)
+	}
+}
+
+// ContentHeaders returns the Content-Type and Content-Encoding header
+// values a sink should set on its upload request for this codec.
+// Content-Encoding is empty for CompressionNone.
+func (c Compression) ContentHeaders() (contentType, contentEncoding string) {
+	if c == CompressionNone {
+		return "application/x-tar", ""
+	}
+	return "application/x-tar", c.String()
+}
+
+// newCompressWriter wraps w with the encoder for c. Callers must Close
+// the returned writer to flush trailing codec state before closing w
+// itself.
+func newCompressWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression %v", c)
+	}
+}
+
+// newDecompressReader wraps r with the decoder for c.
+func newDecompressReader(r io.Reader, c Compression) (io.ReadCloser, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %v", c)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// negotiateCompressionQueryParam is the presigned URL query parameter
+// a server can set to tell agentfs which codec it expects, e.g.
+// "...?x-agentfs-compression=zstd".
+const negotiateCompressionQueryParam = "x-agentfs-compression"
+
+// NegotiateCompression picks a Compression codec for presignedUrl: it
+// first looks for the x-agentfs-compression query parameter on the
+// URL itself, then falls back to the first recognized codec in
+// acceptEncoding (a raw "Accept-Encoding"-style header value), and
+// finally defaults to gzip.
+func NegotiateCompression(presignedUrl string, acceptEncoding string) Compression {
+	if u, err := url.Parse(presignedUrl); err == nil {
+		if hint := u.Query().Get(negotiateCompressionQueryParam); hint != "" {
+			if c, err := ParseCompression(hint); err == nil {
+				return c
+			}
+		}
+	}
+
+	for _, enc := range strings.Split(acceptEncoding, ",") {
+		switch strings.TrimSpace(enc) {
+		case "zstd":
+			return CompressionZstd
+		case "identity":
+			return CompressionNone
+		case "gzip":
+			return CompressionGzip
+		}
+	}
+
+	return CompressionGzip
+}
@@ -0,0 +1,151 @@
+package agentfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCompressionRoundTrip(t *testing.T) {
+	for _, c := range []Compression{CompressionGzip, CompressionZstd, CompressionNone} {
+		got, err := ParseCompression(c.String())
+		if err != nil {
+			t.Fatalf("ParseCompression(%q) returned error: %v", c.String(), err)
+		}
+		if got != c {
+			t.Errorf("ParseCompression(%q) = %v, want %v", c.String(), got, c)
+		}
+	}
+}
+
+func TestParseCompressionUnknown(t *testing.T) {
+	if _, err := ParseCompression("brotli"); err == nil {
+		t.Error("expected an error for an unrecognized codec")
+	}
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		acceptEncoding string
+		want           Compression
+	}{
+		{"query param wins", "https://example.com/put?x-agentfs-compression=zstd", "gzip", CompressionZstd},
+		{"accept-encoding hint", "https://example.com/put", "zstd, gzip", CompressionZstd},
+		{"default is gzip", "https://example.com/put", "", CompressionGzip},
+		{"identity hint", "https://example.com/put", "identity", CompressionNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NegotiateCompression(tt.url, tt.acceptEncoding); got != tt.want {
+				t.Errorf("NegotiateCompression(%q, %q) = %v, want %v", tt.url, tt.acceptEncoding, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressWriterRoundTrip(t *testing.T) {
+	for _, c := range []Compression{CompressionGzip, CompressionZstd, CompressionNone} {
+		c := c
+		t.Run(c.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newCompressWriter(&buf, c)
+			if err != nil {
+				t.Fatalf("newCompressWriter returned error: %v", err)
+			}
+			if _, err := w.Write([]byte("hello compression")); err != nil {
+				t.Fatalf("Write returned error: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close returned error: %v", err)
+			}
+
+			r, err := newDecompressReader(&buf, c)
+			if err != nil {
+				t.Fatalf("newDecompressReader returned error: %v", err)
+			}
+			defer r.Close()
+
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll returned error: %v", err)
+			}
+			if string(data) != "hello compression" {
+				t.Errorf("round-tripped data = %q, want %q", data, "hello compression")
+			}
+		})
+	}
+}
+
+// buildBenchmarkDir creates a small but representative agent
+// directory: a mix of compressible text files and less-compressible
+// binary-ish data, similar in shape to a Python agent checkout.
+func buildBenchmarkDir(b *testing.B) string {
+	b.Helper()
+	dir := b.TempDir()
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20; i++ {
+		data := make([]byte, 64*1024)
+		if _, err := r.Read(data); err != nil {
+			b.Fatal(err)
+		}
+		if i%2 == 0 {
+			// Bias every other file toward compressible, text-like
+			// content so the benchmark isn't all incompressible noise.
+			for j := range data {
+				data[j] = byte('a' + data[j]%26)
+			}
+		}
+		name := filepath.Join(dir, fmt.Sprintf("file-%02d.dat", i))
+		if err := os.WriteFile(name, data, 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// BenchmarkCompression compares wall-clock archiving time and
+// resulting archive size across codecs on the same representative
+// directory. Run with:
+//
+//	go test ./pkg/agentfs/ -bench BenchmarkCompression -benchtime 5x
+func BenchmarkCompression(b *testing.B) {
+	dir := buildBenchmarkDir(b)
+	matcher, err := newTarballIgnoreMatcher(dir, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, c := range []Compression{CompressionGzip, CompressionZstd, CompressionNone} {
+		c := c
+		b.Run(c.String(), func(b *testing.B) {
+			var archiveSize int64
+			for i := 0; i < b.N; i++ {
+				var buf bytes.Buffer
+				compressWriter, err := newCompressWriter(&buf, c)
+				if err != nil {
+					b.Fatal(err)
+				}
+				tarWriter := tar.NewWriter(compressWriter)
+				if err := writeTarball(tarWriter, dir, matcher, TarballOptions{}, io.Discard); err != nil {
+					b.Fatal(err)
+				}
+				if err := tarWriter.Close(); err != nil {
+					b.Fatal(err)
+				}
+				if err := compressWriter.Close(); err != nil {
+					b.Fatal(err)
+				}
+				archiveSize = int64(buf.Len())
+			}
+			b.ReportMetric(float64(archiveSize), "bytes/archive")
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package agentfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ExtractOptions configures ExtractTarball and DownloadTarball.
+type ExtractOptions struct {
+	// Filter, when set, is called once per tar entry before it's
+	// written to disk. Returning false skips the entry entirely,
+	// mirroring the ignore predicate used on the upload side.
+	Filter func(*tar.Header) (bool, error)
+	// Compression is the codec the stream was compressed with. It
+	// must match whatever UploadTarballStream used to build the
+	// archive. Defaults to CompressionGzip.
+	Compression Compression
+}
+
+// ExtractionEscapesRootError is returned when a tar entry's name, or a
+// symlink/hardlink target, would resolve outside destDir. This is the
+// classic "Zip Slip" path-traversal vulnerability; ExtractTarball
+// refuses to write such an entry rather than silently clamping it.
+type ExtractionEscapesRootError struct {
+	Name   string
+	Target string
+}
+
+func (e *ExtractionEscapesRootError) Error() string {
+	return fmt.Sprintf("tar entry %q resolves to %s, which is outside the destination root", e.Name, e.Target)
+}
+
+// DownloadTarball fetches the compressed tarball at presignedUrl and
+// extracts it into destDir, the inverse of UploadTarball.
+func DownloadTarball(presignedUrl string, destDir string, opts ExtractOptions) error {
+	resp, err := http.Get(presignedUrl)
+	if err != nil {
+		return fmt.Errorf("failed to download tarball: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to download tarball: %d: %s", resp.StatusCode, body)
+	}
+
+	if err := ExtractTarball(resp.Body, destDir, opts); err != nil {
+		return fmt.Errorf("failed to extract tarball: %w", err)
+	}
+	return nil
+}
+
+// ExtractTarball reads a compressed tar stream from r and recreates its
+// directories, regular files, and symlinks under destDir with their
+// original mode bits and mtimes. Every entry's resolved path,
+// including symlink and hardlink targets, is required to stay inside
+// destDir; an entry that would escape it is rejected with an
+// *ExtractionEscapesRootError instead of being written.
+func ExtractTarball(r io.Reader, destDir string, opts ExtractOptions) error {
+	decompressReader, err := newDecompressReader(r, opts.Compression)
+	if err != nil {
+		return fmt.Errorf("failed to create decompression reader: %w", err)
+	}
+	defer decompressReader.Close()
+
+	destDir, err = filepath.Abs(destDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve absolute path for %s: %w", destDir, err)
+	}
+
+	tarReader := tar.NewReader(decompressReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+
+		if opts.Filter != nil {
+			keep, err := opts.Filter(header)
+			if err != nil {
+				return fmt.Errorf("filter rejected entry %s: %w", header.Name, err)
+			}
+			if !keep {
+				continue
+			}
+		}
+
+		target, err := safeJoin(destDir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := extractEntry(tarReader, header, target, destDir); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+		}
+	}
+}
+
+func extractEntry(tarReader *tar.Reader, header *tar.Header, target, destDir string) error {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, header.FileInfo().Mode()); err != nil {
+			return err
+		}
+		return chtimes(target, header)
+
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, header.FileInfo().Mode())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, tarReader); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		return chtimes(target, header)
+
+	case tar.TypeSymlink:
+		linkTarget, err := resolveLinkTarget(destDir, filepath.Dir(header.Name), header.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		os.Remove(target)
+		return os.Symlink(relativeTo(filepath.Dir(target), linkTarget), target)
+
+	case tar.TypeLink:
+		linkTarget, err := resolveLinkTarget(destDir, filepath.Dir(header.Name), header.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		os.Remove(target)
+		return os.Link(linkTarget, target)
+
+	default:
+		// Skip device files, fifos, and other entries that don't map
+		// onto a plain extraction.
+		return nil
+	}
+}
+
+func chtimes(target string, header *tar.Header) error {
+	modTime := header.ModTime
+	accessTime := header.AccessTime
+	if accessTime.IsZero() {
+		accessTime = modTime
+	}
+	return os.Chtimes(target, accessTime, modTime)
+}
+
+// safeJoin joins destDir and name the way archive/tar entry names are
+// meant to be interpreted (relative to the archive root) and rejects
+// the result if it doesn't stay inside destDir.
+func safeJoin(destDir, name string) (string, error) {
+	target := filepath.Join(destDir, name)
+	if !isWithinRoot(destDir, target) {
+		return "", &ExtractionEscapesRootError{Name: name, Target: target}
+	}
+	return target, nil
+}
+
+// resolveLinkTarget resolves a symlink/hardlink's Linkname the way tar
+// does: relative to the directory containing the entry itself, not
+// destDir. An absolute Linkname is treated as absolute and therefore
+// rejected unless it happens to already live inside destDir.
+func resolveLinkTarget(destDir, entryRelDir, linkname string) (string, error) {
+	var target string
+	if filepath.IsAbs(linkname) {
+		target = filepath.Clean(linkname)
+	} else {
+		target = filepath.Join(destDir, entryRelDir, linkname)
+	}
+	if !isWithinRoot(destDir, target) {
+		return "", &ExtractionEscapesRootError{Name: linkname, Target: target}
+	}
+	return target, nil
+}
+
+// relativeTo returns target expressed relative to base, falling back
+// to the absolute target if a relative path can't be computed.
+func relativeTo(base, target string) string {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return target
+	}
+	return rel
+}
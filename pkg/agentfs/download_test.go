@@ -0,0 +1,151 @@
+package agentfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestTarball(t *testing.T, entries []tar.Header, contents map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, h := range entries {
+		h := h
+		body := contents[h.Name]
+		h.Size = int64(len(body))
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatalf("WriteHeader(%s) returned error: %v", h.Name, err)
+		}
+		if body != "" {
+			if _, err := tw.Write([]byte(body)); err != nil {
+				t.Fatalf("Write(%s) returned error: %v", h.Name, err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar Close returned error: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("gzip Close returned error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractTarballRecreatesTree(t *testing.T) {
+	archive := writeTestTarball(t, []tar.Header{
+		{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "dir/file.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+	}, map[string]string{"dir/file.txt": "hello"})
+
+	destDir := t.TempDir()
+	if err := ExtractTarball(bytes.NewReader(archive), destDir, ExtractOptions{}); err != nil {
+		t.Fatalf("ExtractTarball returned error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(destDir, "dir", "file.txt"))
+	if err != nil {
+		t.Fatalf("failed to read extracted file: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("extracted content = %q, want %q", data, "hello")
+	}
+}
+
+func TestExtractTarballRejectsPathTraversal(t *testing.T) {
+	archive := writeTestTarball(t, []tar.Header{
+		{Name: "../escape.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+	}, map[string]string{"../escape.txt": "gotcha"})
+
+	destDir := t.TempDir()
+	err := ExtractTarball(bytes.NewReader(archive), destDir, ExtractOptions{})
+
+	var escapeErr *ExtractionEscapesRootError
+	if !errors.As(err, &escapeErr) {
+		t.Fatalf("expected an ExtractionEscapesRootError, got %v", err)
+	}
+}
+
+func TestExtractTarballRejectsSymlinkTraversal(t *testing.T) {
+	archive := writeTestTarball(t, []tar.Header{
+		{Name: "evil-link", Typeflag: tar.TypeSymlink, Linkname: "../../etc/passwd", Mode: 0o777},
+	}, nil)
+
+	destDir := t.TempDir()
+	err := ExtractTarball(bytes.NewReader(archive), destDir, ExtractOptions{})
+
+	var escapeErr *ExtractionEscapesRootError
+	if !errors.As(err, &escapeErr) {
+		t.Fatalf("expected an ExtractionEscapesRootError, got %v", err)
+	}
+}
+
+func TestExtractTarballFilterSkipsEntries(t *testing.T) {
+	archive := writeTestTarball(t, []tar.Header{
+		{Name: "keep.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "skip.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+	}, map[string]string{"keep.txt": "a", "skip.txt": "b"})
+
+	destDir := t.TempDir()
+	opts := ExtractOptions{
+		Filter: func(h *tar.Header) (bool, error) {
+			return h.Name != "skip.txt", nil
+		},
+	}
+	if err := ExtractTarball(bytes.NewReader(archive), destDir, opts); err != nil {
+		t.Fatalf("ExtractTarball returned error: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "keep.txt")); err != nil {
+		t.Errorf("expected keep.txt to be extracted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "skip.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected skip.txt to be skipped, stat returned: %v", err)
+	}
+}
+
+func TestUploadDownloadRoundTrip(t *testing.T) {
+	srcDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "file.txt"), []byte("round trip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	matcher, err := newTarballIgnoreMatcher(srcDir, nil)
+	if err != nil {
+		t.Fatalf("newTarballIgnoreMatcher returned error: %v", err)
+	}
+	if err := writeTarball(tw, srcDir, matcher, TarballOptions{}, io.Discard); err != nil {
+		t.Fatalf("writeTarball returned error: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	destDir := t.TempDir()
+	if err := ExtractTarball(&buf, destDir, ExtractOptions{}); err != nil {
+		t.Fatalf("ExtractTarball returned error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(destDir, "sub", "file.txt"))
+	if err != nil {
+		t.Fatalf("failed to read round-tripped file: %v", err)
+	}
+	if string(data) != "round trip" {
+		t.Errorf("round-tripped content = %q, want %q", data, "round trip")
+	}
+}
@@ -0,0 +1,198 @@
+// Package ignore implements Docker-compatible .dockerignore pattern
+// matching: comments, blank lines, leading "!" re-inclusion, "**"
+// recursive globs, and last-match-wins evaluation order.
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Matcher evaluates slash-relative paths against a compiled set of
+// ignore patterns.
+type Matcher struct {
+	patterns    []*pattern
+	hasNegation bool
+}
+
+type pattern struct {
+	negate bool // pattern had a leading "!": re-include on match
+	re     *regexp.Regexp
+}
+
+// ReadFile reads a .dockerignore-style file and returns its patterns
+// with comments and blank lines stripped. It returns an error
+// satisfying os.IsNotExist if path does not exist, so callers can treat
+// a missing file as "no patterns".
+func ReadFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+// New compiles patterns into a Matcher. Patterns are interpreted
+// relative to the context root: a leading "/" is stripped rather than
+// treated as an absolute path, "*" matches within a single path
+// component, "**" matches zero or more components, and a leading "!"
+// re-includes a path excluded by an earlier pattern.
+func New(patterns []string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+
+		negate := false
+		if strings.HasPrefix(p, "!") {
+			if len(p) == 1 {
+				return nil, fmt.Errorf("illegal pattern %q: \"!\" with no content", p)
+			}
+			negate = true
+			p = p[1:]
+		}
+
+		p = filepath.ToSlash(filepath.Clean(p))
+		p = strings.TrimPrefix(p, "/")
+
+		re, err := compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+
+		m.patterns = append(m.patterns, &pattern{negate: negate, re: re})
+		if negate {
+			m.hasNegation = true
+		}
+	}
+	return m, nil
+}
+
+// HasNegation reports whether any compiled pattern re-includes a path
+// (a leading "!"). Callers that prune directory trees on an exclusion
+// match must not do so when this is true, since a later pattern may
+// still re-include a descendant.
+func (m *Matcher) HasNegation() bool {
+	return m.hasNegation
+}
+
+// Match reports whether relPath (slash- or OS-separated, relative to
+// the context root) is excluded by the pattern set. Patterns are
+// evaluated in order, last match wins, and a pattern matches a path if
+// it matches the path itself or any of its parent directories, so that
+// a bare directory pattern like "logs" also excludes everything below
+// it.
+func (m *Matcher) Match(relPath string) (bool, error) {
+	relPath = filepath.ToSlash(filepath.Clean(relPath))
+	if relPath == "." {
+		return false, nil
+	}
+	parts := strings.Split(relPath, "/")
+
+	matched := false
+	for _, p := range m.patterns {
+		// An exclusion pattern can only ever flip matched from false to
+		// true, and a re-inclusion pattern only from true to false, so
+		// once we're on the "wrong side" for a pattern it can't change
+		// the outcome and evaluating it is wasted work.
+		if p.negate != matched {
+			continue
+		}
+
+		found := false
+		for i := 1; i <= len(parts); i++ {
+			if p.re.MatchString(strings.Join(parts[:i], "/")) {
+				found = true
+				break
+			}
+		}
+		if found {
+			matched = !p.negate
+		}
+	}
+	return matched, nil
+}
+
+// compile translates a single dockerignore-style pattern into an
+// anchored regexp: "*" matches any run of characters except "/", "?"
+// matches exactly one non-"/" character, "**" matches zero or more
+// path components, "[...]" is a character class, "\" makes the next
+// character literal, and everything else is matched literally.
+func compile(p string) (*regexp.Regexp, error) {
+	var out strings.Builder
+	out.WriteString("^")
+
+	runes := []rune(p)
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+		switch {
+		case ch == '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				i++ // consume second '*'
+				if i+1 < len(runes) && runes[i+1] == '/' {
+					i++ // "**/"  also eats the separator
+				}
+				if i+1 >= len(runes) {
+					out.WriteString(".*") // trailing "**" matches everything below
+				} else {
+					out.WriteString("(.*/)?")
+				}
+			} else {
+				out.WriteString("[^/]*")
+			}
+		case ch == '?':
+			out.WriteString("[^/]")
+		case strings.ContainsRune(`.+()|{}$^`, ch):
+			out.WriteString(regexp.QuoteMeta(string(ch)))
+		case ch == '[':
+			// Glob bracket classes negate with a leading "!", which has
+			// no special meaning in a Go regexp character class (where
+			// negation is "^"); translate it, leaving an already-"^"
+			// class untouched.
+			if i+1 < len(runes) && (runes[i+1] == '!' || runes[i+1] == '^') {
+				out.WriteString("[^")
+				i++
+			} else {
+				out.WriteRune(ch)
+			}
+		case ch == ']':
+			out.WriteRune(ch)
+		case ch == '\\':
+			// Docker's escaping rule: "\" makes the following character
+			// literal, e.g. "\*" matches a literal "*". A trailing "\"
+			// with nothing to escape is matched literally too.
+			if i+1 < len(runes) {
+				i++
+				out.WriteString(regexp.QuoteMeta(string(runes[i])))
+			} else {
+				out.WriteString(regexp.QuoteMeta(string(ch)))
+			}
+		default:
+			out.WriteRune(ch)
+		}
+	}
+
+	out.WriteString("$")
+	return regexp.Compile(out.String())
+}
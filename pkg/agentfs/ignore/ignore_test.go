@@ -0,0 +1,71 @@
+package ignore
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		want     bool
+	}{
+		{"plain file", []string{"logs"}, "logs", true},
+		{"plain dir excludes children", []string{"logs"}, "logs/error.log", true},
+		{"unrelated file kept", []string{"logs"}, "src/main.go", false},
+		{"double star suffix", []string{"**/*.pyc"}, "pkg/module.pyc", true},
+		{"double star suffix at root", []string{"**/*.pyc"}, "module.pyc", true},
+		{"double star suffix miss", []string{"**/*.pyc"}, "module.py", false},
+		{"double star middle", []string{"a/**/b"}, "a/b", true},
+		{"double star middle nested", []string{"a/**/b"}, "a/x/y/b", true},
+		{"double star middle miss", []string{"a/**/b"}, "a/x/y/c", false},
+		{"negation re-includes a file", []string{"logs", "!logs/keep.log"}, "logs/keep.log", false},
+		{"negation does not affect siblings", []string{"logs", "!logs/keep.log"}, "logs/other.log", true},
+		{"comment is not a pattern", []string{"# logs", "logs"}, "logs", true},
+		{"single star does not cross separators", []string{"*.log"}, "sub/app.log", false},
+		{"single star within a component", []string{"*.log"}, "app.log", true},
+		{"leading slash is anchored to root", []string{"/build"}, "build", true},
+		{"last match wins across many patterns", []string{"*", "!keep.txt", "keep.txt"}, "keep.txt", true},
+		{"bracket class negation excludes non-digit", []string{"file[!0-9].txt"}, "filea.txt", true},
+		{"bracket class negation keeps digit", []string{"file[!0-9].txt"}, "file5.txt", false},
+		{"bracket class matches listed char", []string{"file[0-9].txt"}, "file5.txt", true},
+		{"bracket class misses unlisted char", []string{"file[0-9].txt"}, "filea.txt", false},
+		{"backslash escapes a literal character", []string{`file\d.txt`}, "filed.txt", true},
+		{"backslash-escaped character is not a regex class", []string{`file\d.txt`}, "file5.txt", false},
+		{"backslash escapes a glob metacharacter", []string{`\*.txt`}, "*.txt", true},
+		{"escaped metacharacter no longer globs", []string{`\*.txt`}, "app.txt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New(tt.patterns)
+			if err != nil {
+				t.Fatalf("New(%v) returned error: %v", tt.patterns, err)
+			}
+			got, err := m.Match(tt.path)
+			if err != nil {
+				t.Fatalf("Match(%q) returned error: %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("Match(%q) with patterns %v = %v, want %v", tt.path, tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasNegation(t *testing.T) {
+	m, err := New([]string{"logs", "!logs/keep.log"})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	if !m.HasNegation() {
+		t.Error("HasNegation() = false, want true")
+	}
+
+	m, err = New([]string{"logs"})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	if m.HasNegation() {
+		t.Error("HasNegation() = true, want false")
+	}
+}
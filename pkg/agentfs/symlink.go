@@ -0,0 +1,250 @@
+package agentfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkMode controls how symlinks in the archived directory are
+// represented in the tarball, modeled on Docker's own archive package.
+type SymlinkMode int
+
+const (
+	// SymlinkPreserve writes a tar.TypeSymlink header with Linkname
+	// set to the symlink's original target, leaving it to whatever
+	// extracts the archive to resolve it. This is the default.
+	SymlinkPreserve SymlinkMode = iota
+	// SymlinkFollowInternal dereferences a symlink and archives its
+	// target's content under the link's name, but only when the
+	// resolved target stays inside the context root; a symlink whose
+	// target escapes the root is preserved instead.
+	SymlinkFollowInternal
+	// SymlinkFollowAll dereferences every symlink, guarding against
+	// cyclic links, but still rejects a symlink whose resolved target
+	// escapes the context root rather than embedding foreign content.
+	SymlinkFollowAll
+)
+
+// TarballOptions configures how the archive itself is built: how
+// filesystem entries that plain tar headers can't express directly
+// are represented, and which codec compresses the resulting stream.
+type TarballOptions struct {
+	SymlinkMode SymlinkMode
+	// Compression selects the codec wrapping the tar stream. The zero
+	// value is CompressionGzip.
+	Compression Compression
+}
+
+// maxSymlinkDepth bounds how many hops writeSymlinkEntry will follow
+// before concluding a symlink chain is cyclic.
+const maxSymlinkDepth = 40
+
+// SymlinkLoopError is returned when a symlink chain does not resolve
+// within maxSymlinkDepth hops.
+type SymlinkLoopError struct {
+	Path string
+}
+
+func (e *SymlinkLoopError) Error() string {
+	return fmt.Sprintf("symlink %s did not resolve after %d hops, possible cycle", e.Path, maxSymlinkDepth)
+}
+
+// SymlinkEscapesRootError is returned when a symlink's resolved target
+// falls outside the context root directory and the active SymlinkMode
+// has no safe way to represent that in the archive.
+type SymlinkEscapesRootError struct {
+	Path   string
+	Target string
+}
+
+func (e *SymlinkEscapesRootError) Error() string {
+	return fmt.Sprintf("symlink %s resolves to %s, which is outside the context root", e.Path, e.Target)
+}
+
+// resolveSymlink follows path's symlink chain one hop at a time,
+// stat'ing each hop and comparing it against every previously visited
+// entry with os.SameFile so a cycle (e.g. a -> b -> a) is reported as
+// a SymlinkLoopError instead of spinning forever.
+func resolveSymlink(path string) (string, os.FileInfo, error) {
+	visited := make([]os.FileInfo, 0, 8)
+	current := path
+
+	for i := 0; i < maxSymlinkDepth; i++ {
+		lst, err := os.Lstat(current)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to stat %s: %w", current, err)
+		}
+		for _, seen := range visited {
+			if os.SameFile(seen, lst) {
+				return "", nil, &SymlinkLoopError{Path: path}
+			}
+		}
+
+		if lst.Mode()&os.ModeSymlink == 0 {
+			return current, lst, nil
+		}
+		visited = append(visited, lst)
+
+		link, err := os.Readlink(current)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read symlink %s: %w", current, err)
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(current), link)
+		}
+		current = filepath.Clean(link)
+	}
+	return "", nil, &SymlinkLoopError{Path: path}
+}
+
+// isWithinRoot reports whether target (an absolute, cleaned path) is
+// root itself or falls inside it.
+func isWithinRoot(root, target string) bool {
+	if target == root {
+		return true
+	}
+	return strings.HasPrefix(target, root+string(os.PathSeparator))
+}
+
+// writeSymlinkEntry writes the tar entry for the symlink at path
+// (relPath within the archive), following opts.SymlinkMode to decide
+// whether to preserve it as a symlink header or dereference it into
+// real file/directory content.
+func writeSymlinkEntry(tarWriter *tar.Writer, path, relPath, rootAbs string, opts TarballOptions, progress io.Writer) error {
+	if opts.SymlinkMode == SymlinkPreserve {
+		return writePreservedSymlink(tarWriter, path, relPath)
+	}
+
+	realPath, realInfo, err := resolveSymlink(path)
+	if err != nil {
+		return err
+	}
+
+	if !isWithinRoot(rootAbs, realPath) {
+		if opts.SymlinkMode == SymlinkFollowInternal {
+			return writePreservedSymlink(tarWriter, path, relPath)
+		}
+		return &SymlinkEscapesRootError{Path: relPath, Target: realPath}
+	}
+
+	return writeDereferencedEntry(tarWriter, realPath, relPath, realInfo, rootAbs, opts, progress)
+}
+
+func writePreservedSymlink(tarWriter *tar.Writer, path, relPath string) error {
+	linkname, err := os.Readlink(path)
+	if err != nil {
+		return fmt.Errorf("failed to read symlink %s: %w", path, err)
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat symlink %s: %w", path, err)
+	}
+
+	header, err := tar.FileInfoHeader(info, linkname)
+	if err != nil {
+		return fmt.Errorf("failed to create tar header for symlink %s: %w", path, err)
+	}
+	header.Name = relPath
+	header.Typeflag = tar.TypeSymlink
+	header.Linkname = linkname
+
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header for symlink %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeDereferencedEntry writes realPath's content under relPath, as
+// if the symlink were its target. A directory target is walked
+// recursively so its full contents are archived rather than a single
+// empty directory header; a nested symlink is itself run back through
+// writeSymlinkEntry so opts.SymlinkMode and the root-escape check keep
+// applying at every level.
+func writeDereferencedEntry(tarWriter *tar.Writer, realPath, relPath string, info os.FileInfo, rootAbs string, opts TarballOptions, progress io.Writer) error {
+	if info.IsDir() {
+		return writeDereferencedDir(tarWriter, realPath, relPath, rootAbs, opts, progress)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	return writeDereferencedFile(tarWriter, realPath, relPath, info, progress)
+}
+
+// writeDereferencedDir writes a directory header for realPath under
+// relPath, then recurses into every entry it contains.
+func writeDereferencedDir(tarWriter *tar.Writer, realPath, relPath, rootAbs string, opts TarballOptions, progress io.Writer) error {
+	info, err := os.Lstat(realPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat directory %s: %w", realPath, err)
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return fmt.Errorf("failed to create tar header for directory %s: %w", realPath, err)
+	}
+	header.Name = relPath + "/"
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header for directory %s: %w", realPath, err)
+	}
+
+	children, err := os.ReadDir(realPath)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %w", realPath, err)
+	}
+
+	for _, child := range children {
+		childPath := filepath.Join(realPath, child.Name())
+		childRel := relPath + "/" + child.Name()
+
+		childInfo, err := child.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", childPath, err)
+		}
+
+		switch {
+		case childInfo.Mode()&os.ModeSymlink != 0:
+			if err := writeSymlinkEntry(tarWriter, childPath, childRel, rootAbs, opts, progress); err != nil {
+				return err
+			}
+		case childInfo.IsDir():
+			if err := writeDereferencedDir(tarWriter, childPath, childRel, rootAbs, opts, progress); err != nil {
+				return err
+			}
+		case childInfo.Mode().IsRegular():
+			if err := writeDereferencedFile(tarWriter, childPath, childRel, childInfo, progress); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeDereferencedFile writes realPath's content as a regular file
+// under relPath.
+func writeDereferencedFile(tarWriter *tar.Writer, realPath, relPath string, info os.FileInfo, progress io.Writer) error {
+	file, err := os.Open(realPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", realPath, err)
+	}
+	defer file.Close()
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return fmt.Errorf("failed to create tar header for file %s: %w", realPath, err)
+	}
+	header.Name = relPath
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header for file %s: %w", realPath, err)
+	}
+
+	reader := io.TeeReader(file, progress)
+	if _, err := io.Copy(tarWriter, reader); err != nil {
+		return fmt.Errorf("failed to copy file content for %s: %w", realPath, err)
+	}
+	return nil
+}
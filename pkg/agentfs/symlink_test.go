@@ -0,0 +1,181 @@
+package agentfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/livekit/livekit-cli/pkg/agentfs/ignore"
+)
+
+func readTarHeaders(t *testing.T, buf *bytes.Buffer) map[string]*tar.Header {
+	t.Helper()
+	headers := make(map[string]*tar.Header)
+	tr := tar.NewReader(buf)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar header: %v", err)
+		}
+		headers[h.Name] = h
+	}
+	return headers
+}
+
+func buildTarball(t *testing.T, dir string, opts TarballOptions) (map[string]*tar.Header, error) {
+	t.Helper()
+	matcher, err := ignore.New(nil)
+	if err != nil {
+		t.Fatalf("ignore.New returned error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	err = writeTarball(tw, dir, matcher, opts, io.Discard)
+	if closeErr := tw.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readTarHeaders(t, &buf), nil
+}
+
+func TestWriteTarballSymlinkPreserve(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "real.txt"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real.txt", filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	headers, err := buildTarball(t, dir, TarballOptions{SymlinkMode: SymlinkPreserve})
+	if err != nil {
+		t.Fatalf("writeTarball returned error: %v", err)
+	}
+
+	h, ok := headers["link.txt"]
+	if !ok {
+		t.Fatal("expected a header for link.txt")
+	}
+	if h.Typeflag != tar.TypeSymlink {
+		t.Errorf("Typeflag = %v, want TypeSymlink", h.Typeflag)
+	}
+	if h.Linkname != "real.txt" {
+		t.Errorf("Linkname = %q, want %q", h.Linkname, "real.txt")
+	}
+}
+
+func TestWriteTarballSymlinkFollowInternal(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "real.txt"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real.txt", filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	outsideDir := t.TempDir()
+	outsideFile := filepath.Join(outsideDir, "secret.txt")
+	if err := os.WriteFile(outsideFile, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outsideFile, filepath.Join(dir, "escape.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	headers, err := buildTarball(t, dir, TarballOptions{SymlinkMode: SymlinkFollowInternal})
+	if err != nil {
+		t.Fatalf("writeTarball returned error: %v", err)
+	}
+
+	if h := headers["link.txt"]; h == nil || h.Typeflag != tar.TypeReg {
+		t.Errorf("expected link.txt to be dereferenced into a regular file, got %+v", h)
+	}
+	if h := headers["escape.txt"]; h == nil || h.Typeflag != tar.TypeSymlink {
+		t.Errorf("expected escape.txt to be preserved as a symlink since its target is outside the root, got %+v", h)
+	}
+}
+
+func TestWriteTarballSymlinkFollowInternalRecursesIntoDirectory(t *testing.T) {
+	dir := t.TempDir()
+	realDir := filepath.Join(dir, "realdir")
+	if err := os.Mkdir(realDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(realDir, "inner.txt"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	nestedDir := filepath.Join(realDir, "nested")
+	if err := os.Mkdir(nestedDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(nestedDir, "deep.txt"), []byte("deep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("realdir", filepath.Join(dir, "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+
+	headers, err := buildTarball(t, dir, TarballOptions{SymlinkMode: SymlinkFollowInternal})
+	if err != nil {
+		t.Fatalf("writeTarball returned error: %v", err)
+	}
+
+	if h := headers["linkdir/"]; h == nil || h.Typeflag != tar.TypeDir {
+		t.Fatalf("expected linkdir/ to be a directory header, got %+v", h)
+	}
+	if h := headers["linkdir/inner.txt"]; h == nil || h.Typeflag != tar.TypeReg {
+		t.Errorf("expected linkdir/inner.txt to be archived as a regular file, got %+v", h)
+	}
+	if h := headers["linkdir/nested/"]; h == nil || h.Typeflag != tar.TypeDir {
+		t.Errorf("expected linkdir/nested/ to be archived as a directory, got %+v", h)
+	}
+	if h := headers["linkdir/nested/deep.txt"]; h == nil || h.Typeflag != tar.TypeReg {
+		t.Errorf("expected linkdir/nested/deep.txt to be archived as a regular file, got %+v", h)
+	}
+}
+
+func TestWriteTarballSymlinkFollowAllRejectsEscape(t *testing.T) {
+	dir := t.TempDir()
+	outsideDir := t.TempDir()
+	outsideFile := filepath.Join(outsideDir, "secret.txt")
+	if err := os.WriteFile(outsideFile, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outsideFile, filepath.Join(dir, "escape.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := buildTarball(t, dir, TarballOptions{SymlinkMode: SymlinkFollowAll})
+	var escapeErr *SymlinkEscapesRootError
+	if !errors.As(err, &escapeErr) {
+		t.Fatalf("expected a SymlinkEscapesRootError, got %v", err)
+	}
+}
+
+func TestWriteTarballSymlinkLoop(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	if err := os.Symlink(b, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(a, b); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := buildTarball(t, dir, TarballOptions{SymlinkMode: SymlinkFollowAll})
+	var loopErr *SymlinkLoopError
+	if !errors.As(err, &loopErr) {
+		t.Fatalf("expected a SymlinkLoopError, got %v", err)
+	}
+}
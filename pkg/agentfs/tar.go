@@ -2,45 +2,53 @@ package agentfs
 
 import (
 	"archive/tar"
-	"bytes"
-	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/schollz/progressbar/v3"
 
 	"github.com/livekit/protocol/logger"
+
+	"github.com/livekit/livekit-cli/pkg/agentfs/ignore"
 )
 
+// standardTarballExcludeFiles are always fed into the ignore matcher
+// alongside the context's .dockerignore and any caller-supplied
+// patterns. Dockerfile and .dockerignore are deliberately absent, but
+// they get no special treatment beyond that: any pattern that matches
+// them excludes them, including a wildcard such as "*" that does not
+// name them.
 var standardTarballExcludeFiles = []string{
-	"Dockerfile",
-	".dockerignore",
 	".gitignore",
 	".git",
 	"node_modules",
 	"*.env",
 }
 
-func UploadTarball(directory string, presignedUrl string, excludeFiles []string) error {
-	excludeFiles = append(standardTarballExcludeFiles, excludeFiles...)
+func newTarballIgnoreMatcher(directory string, excludeFiles []string) (*ignore.Matcher, error) {
+	patterns := append([]string{}, standardTarballExcludeFiles...)
+	patterns = append(patterns, excludeFiles...)
 
-	dockerIgnore := filepath.Join(directory, ".dockerignore")
-	if _, err := os.Stat(dockerIgnore); err == nil {
-		content, err := os.ReadFile(dockerIgnore)
-		if err != nil {
-			return fmt.Errorf("failed to read .dockerignore: %w", err)
-		}
-		excludeFiles = append(excludeFiles, strings.Split(string(content), "\n")...)
+	dockerIgnorePatterns, err := ignore.ReadFile(filepath.Join(directory, ".dockerignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read .dockerignore: %w", err)
 	}
+	patterns = append(patterns, dockerIgnorePatterns...)
 
-	for i, exclude := range excludeFiles {
-		excludeFiles[i] = strings.TrimSpace(exclude)
+	matcher, err := ignore.New(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ignore patterns: %w", err)
 	}
+	return matcher, nil
+}
 
+// calculateTotalSize sums the size of every regular file under
+// directory that matcher does not exclude, used to size the
+// "Compressing files" progress bar up front.
+func calculateTotalSize(directory string, matcher *ignore.Matcher) (int64, error) {
 	var totalSize int64
 	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -52,22 +60,15 @@ func UploadTarball(directory string, presignedUrl string, excludeFiles []string)
 			return nil
 		}
 
-		for _, exclude := range excludeFiles {
-			if exclude == "" || strings.Contains(exclude, "Dockerfile") {
-				continue
-			}
-			if info.IsDir() {
-				if strings.HasPrefix(relPath, exclude+"/") || strings.HasPrefix(relPath, exclude) {
-					return filepath.SkipDir
-				}
-			}
-			matched, err := filepath.Match(exclude, relPath)
-			if err != nil {
-				return nil
-			}
-			if matched {
-				return nil
+		excluded, err := matcher.Match(relPath)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			if info.IsDir() && !matcher.HasNegation() {
+				return filepath.SkipDir
 			}
+			return nil
 		}
 
 		if !info.IsDir() && info.Mode().IsRegular() {
@@ -76,28 +77,19 @@ func UploadTarball(directory string, presignedUrl string, excludeFiles []string)
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("failed to calculate total size: %w", err)
+		return 0, fmt.Errorf("failed to calculate total size: %w", err)
 	}
+	return totalSize, nil
+}
 
-	tarProgress := progressbar.NewOptions64(
-		totalSize,
-		progressbar.OptionSetDescription("Compressing files"),
-		progressbar.OptionSetWidth(30),
-		progressbar.OptionShowBytes(true),
-		progressbar.OptionSetTheme(progressbar.Theme{
-			Saucer:        "=",
-			SaucerHead:    ">",
-			SaucerPadding: " ",
-			BarStart:      "[",
-			BarEnd:        "]",
-		}),
-	)
-
-	var buffer bytes.Buffer
-	gzipWriter := gzip.NewWriter(&buffer)
-	defer gzipWriter.Close()
-	tarWriter := tar.NewWriter(gzipWriter)
-	defer tarWriter.Close()
+// writeTarball walks directory and writes every file matcher does not
+// exclude into tarWriter, reporting bytes read from disk to progress.
+// Symlinks are represented according to opts.SymlinkMode.
+func writeTarball(tarWriter *tar.Writer, directory string, matcher *ignore.Matcher, opts TarballOptions, progress io.Writer) error {
+	rootAbs, err := filepath.Abs(directory)
+	if err != nil {
+		return fmt.Errorf("failed to resolve absolute path for %s: %w", directory, err)
+	}
 
 	err = filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -109,38 +101,24 @@ func UploadTarball(directory string, presignedUrl string, excludeFiles []string)
 			return fmt.Errorf("failed to calculate relative path for %s: %w", path, err)
 		}
 
-		for _, exclude := range excludeFiles {
-			if exclude == "" || strings.Contains(exclude, "Dockerfile") {
-				continue
-			}
-
+		excluded, err := matcher.Match(relPath)
+		if err != nil {
+			return err
+		}
+		if excluded {
 			if info.IsDir() {
-				if strings.HasPrefix(relPath, exclude+"/") || strings.HasPrefix(relPath, exclude) {
-					logger.Debugw("excluding directory from tarball", "path", path)
+				logger.Debugw("excluding directory from tarball", "path", path)
+				if !matcher.HasNegation() {
 					return filepath.SkipDir
 				}
-			}
-
-			matched, err := filepath.Match(exclude, relPath)
-			if err != nil {
-				return nil
-			}
-			if matched {
+			} else {
 				logger.Debugw("excluding file from tarball", "path", path)
-				return nil
 			}
+			return nil
 		}
 
-		// Handle symlinks and get the real FileInfo if it's a symlink
 		if info.Mode()&os.ModeSymlink != 0 {
-			realPath, err := filepath.EvalSymlinks(path)
-			if err != nil {
-				return fmt.Errorf("failed to evaluate symlink %s: %w", path, err)
-			}
-			info, err = os.Stat(realPath)
-			if err != nil {
-				return fmt.Errorf("failed to stat %s: %w", realPath, err)
-			}
+			return writeSymlinkEntry(tarWriter, path, relPath, rootAbs, opts, progress)
 		}
 
 		// Handle directories
@@ -177,28 +155,23 @@ func UploadTarball(directory string, presignedUrl string, excludeFiles []string)
 			return fmt.Errorf("failed to write tar header for file %s: %w", path, err)
 		}
 
-		reader := io.TeeReader(file, tarProgress)
+		reader := io.TeeReader(file, progress)
 		_, err = io.Copy(tarWriter, reader)
 		if err != nil {
 			return fmt.Errorf("failed to copy file content for %s: %w", path, err)
 		}
 		return nil
 	})
-
 	if err != nil {
 		return fmt.Errorf("failed to walk directory: %w", err)
 	}
+	return nil
+}
 
-	if err := tarWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close tar writer: %w", err)
-	}
-	if err := gzipWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close gzip writer: %w", err)
-	}
-
-	uploadProgress := progressbar.NewOptions64(
-		int64(buffer.Len()),
-		progressbar.OptionSetDescription("Uploading"),
+func newTarballProgressBar(total int64, description string) *progressbar.ProgressBar {
+	return progressbar.NewOptions64(
+		total,
+		progressbar.OptionSetDescription(description),
 		progressbar.OptionSetWidth(30),
 		progressbar.OptionShowBytes(true),
 		progressbar.OptionSetTheme(progressbar.Theme{
@@ -209,26 +182,20 @@ func UploadTarball(directory string, presignedUrl string, excludeFiles []string)
 			BarEnd:        "]",
 		}),
 	)
+}
 
-	req, err := http.NewRequest("PUT", presignedUrl, io.TeeReader(&buffer, uploadProgress))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/gzip")
-	req.ContentLength = int64(buffer.Len())
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to upload tarball: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to upload tarball: %d: %s", resp.StatusCode, body)
-	}
-
-	fmt.Println()
-	return nil
+// UploadTarball archives directory and PUTs the compressed tarball to
+// presignedUrl in a single streamed request, using the codec that
+// NegotiateCompression picks from the URL (gzip unless the URL's
+// x-agentfs-compression parameter names another). It is a thin wrapper
+// around UploadTarballStream using a SinglePutSink; new callers that
+// need multipart or other sink behavior should call
+// UploadTarballStream directly.
+func UploadTarball(directory string, presignedUrl string, excludeFiles []string) error {
+	compression := NegotiateCompression(presignedUrl, "")
+	sink := &SinglePutSink{PresignedUrl: presignedUrl, Compression: compression}
+	return UploadTarballStream(context.Background(), directory, sink, UploadOptions{
+		ExcludeFiles: excludeFiles,
+		Tarball:      TarballOptions{Compression: compression},
+	})
 }
@@ -0,0 +1,236 @@
+package agentfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// defaultPartSize is the chunk size a MultipartSink uses when its own
+// PartSize is unset.
+const defaultPartSize = 8 << 20 // 8 MiB
+
+// UploadOptions configures an archive produced by UploadTarballStream
+// or the legacy UploadTarball. It does not configure the sink itself;
+// set fields like MultipartSink.PartSize on the sink before passing it
+// to UploadTarballStream.
+type UploadOptions struct {
+	// ExcludeFiles are additional dockerignore-style patterns applied
+	// on top of the standard excludes and the context's .dockerignore.
+	ExcludeFiles []string
+	// Tarball controls how the archive itself is built, e.g. how
+	// symlinks are represented.
+	Tarball TarballOptions
+}
+
+// UploadSink delivers a compressed tarball stream to its destination.
+// SinglePutSink issues one streaming PUT; MultipartSink splits the
+// stream across several presigned part URLs. Other backends (Azure
+// block blobs, TUS) can plug in by implementing this interface.
+type UploadSink interface {
+	Send(ctx context.Context, r io.Reader) error
+}
+
+// SinglePutSink uploads the entire tarball as one streamed HTTP PUT,
+// with no size known up front. This matches the archive-level
+// behavior agentfs has always had, minus buffering the whole archive
+// in memory first.
+type SinglePutSink struct {
+	PresignedUrl string
+	Client       *http.Client
+	// Compression is used only to set the Content-Type/Content-Encoding
+	// headers on the PUT request; it must match the Compression the
+	// archive was built with. Defaults to CompressionGzip.
+	Compression Compression
+}
+
+func (s *SinglePutSink) Send(ctx context.Context, r io.Reader) error {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.PresignedUrl, r)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	contentType, contentEncoding := s.Compression.ContentHeaders()
+	req.Header.Set("Content-Type", contentType)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload tarball: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to upload tarball: %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// MultipartSink splits the tarball stream into fixed-size parts, PUTs
+// each to its own presigned URL with retry on server errors, and
+// finalizes the upload by calling Complete once every part has
+// succeeded.
+type MultipartSink struct {
+	// PartUrls holds one presigned PUT URL per part, in order.
+	PartUrls []string
+	// PartSize is the chunk size to split the stream into. Defaults
+	// to defaultPartSize.
+	PartSize int64
+	// Complete finalizes the upload, e.g. by calling a "complete
+	// multipart upload" endpoint on the server that issued PartUrls.
+	Complete func(ctx context.Context) error
+	Client   *http.Client
+	// MaxAttempts is the number of times a single part is attempted
+	// before giving up. Defaults to 3.
+	MaxAttempts int
+	// Compression is used only to set the Content-Type/Content-Encoding
+	// headers on each part's PUT request; it must match the
+	// Compression the archive was built with. Defaults to
+	// CompressionGzip.
+	Compression Compression
+}
+
+func (s *MultipartSink) Send(ctx context.Context, r io.Reader) error {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxAttempts := s.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	partSize := s.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+
+	buf := make([]byte, partSize)
+	for partNumber := 1; ; partNumber++ {
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read part %d: %w", partNumber, readErr)
+		}
+		if n == 0 {
+			break
+		}
+		if partNumber > len(s.PartUrls) {
+			return fmt.Errorf("tarball needs more than the %d presigned part URLs provided", len(s.PartUrls))
+		}
+
+		if err := s.sendPartWithRetry(ctx, client, partNumber, buf[:n], maxAttempts); err != nil {
+			return err
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+
+	if s.Complete != nil {
+		if err := s.Complete(ctx); err != nil {
+			return fmt.Errorf("failed to complete multipart upload: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *MultipartSink) sendPartWithRetry(ctx context.Context, client *http.Client, partNumber int, chunk []byte, maxAttempts int) error {
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.PartUrls[partNumber-1], bytes.NewReader(chunk))
+		if err != nil {
+			return fmt.Errorf("failed to create request for part %d: %w", partNumber, err)
+		}
+		req.ContentLength = int64(len(chunk))
+		contentType, contentEncoding := s.Compression.ContentHeaders()
+		req.Header.Set("Content-Type", contentType)
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("failed to upload part %d: %d: %s", partNumber, resp.StatusCode, body)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to upload part %d: %d: %s", partNumber, resp.StatusCode, body)
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// UploadTarballStream archives directory according to opts and streams
+// the compressed tarball straight into sink without ever buffering the
+// full archive in memory. Archiving (directory walk, ignore matching,
+// tar encoding and opts.Tarball.Compression) runs on a background goroutine feeding an
+// io.Pipe; sink decides how those bytes reach their destination,
+// whether as one streaming PUT or several multipart parts.
+func UploadTarballStream(ctx context.Context, directory string, sink UploadSink, opts UploadOptions) error {
+	matcher, err := newTarballIgnoreMatcher(directory, opts.ExcludeFiles)
+	if err != nil {
+		return err
+	}
+
+	totalSize, err := calculateTotalSize(directory, matcher)
+	if err != nil {
+		return err
+	}
+	progress := newTarballProgressBar(totalSize, "Compressing files")
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		compressWriter, err := newCompressWriter(pw, opts.Tarball.Compression)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		tarWriter := tar.NewWriter(compressWriter)
+
+		if err := writeTarball(tarWriter, directory, matcher, opts.Tarball, progress); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if err := tarWriter.Close(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if err := compressWriter.Close(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.Close()
+	}()
+
+	if err := sink.Send(ctx, pr); err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+
+	fmt.Println()
+	return nil
+}
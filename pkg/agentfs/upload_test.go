@@ -0,0 +1,116 @@
+package agentfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSinglePutSinkSend(t *testing.T) {
+	var received []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		received, err = io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read body: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink := &SinglePutSink{PresignedUrl: srv.URL}
+	payload := []byte("hello tarball")
+	if err := sink.Send(context.Background(), bytes.NewReader(payload)); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Errorf("server received %q, want %q", received, payload)
+	}
+}
+
+func TestMultipartSinkSplitsIntoParts(t *testing.T) {
+	var gotParts [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotParts = append(gotParts, body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	completed := false
+	sink := &MultipartSink{
+		PartUrls: []string{srv.URL, srv.URL, srv.URL},
+		PartSize: 4,
+		Complete: func(ctx context.Context) error {
+			completed = true
+			return nil
+		},
+	}
+
+	if err := sink.Send(context.Background(), bytes.NewReader([]byte("abcdefghij"))); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+
+	want := [][]byte{[]byte("abcd"), []byte("efgh"), []byte("ij")}
+	if len(gotParts) != len(want) {
+		t.Fatalf("got %d parts, want %d", len(gotParts), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(gotParts[i], want[i]) {
+			t.Errorf("part %d = %q, want %q", i, gotParts[i], want[i])
+		}
+	}
+	if !completed {
+		t.Error("Complete callback was not invoked")
+	}
+}
+
+func TestMultipartSinkRetriesOnServerError(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink := &MultipartSink{
+		PartUrls:    []string{srv.URL},
+		PartSize:    64,
+		MaxAttempts: 2,
+	}
+
+	if err := sink.Send(context.Background(), bytes.NewReader([]byte("short"))); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("server got %d attempts, want 2", attempts)
+	}
+}
+
+func TestMultipartSinkTooManyParts(t *testing.T) {
+	// The one part URL accepts its part, so the only error left is
+	// running out of part URLs for the second part.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink := &MultipartSink{
+		PartUrls: []string{srv.URL},
+		PartSize: 2,
+	}
+
+	err := sink.Send(context.Background(), bytes.NewReader([]byte("abcdef")))
+	if err == nil {
+		t.Fatal("expected an error when the stream needs more parts than URLs provided")
+	}
+}